@@ -0,0 +1,142 @@
+package handler
+
+import (
+	"sync"
+	"time"
+
+	"github.com/bookreport/graphql"
+	"golang.org/x/net/context"
+)
+
+// apolloTracingVersion is the version field of the extensions.tracing
+// object, per https://github.com/apollographql/apollo-tracing.
+const apolloTracingVersion = 1
+
+type apolloTracingOffset struct {
+	StartOffset int64 `json:"startOffset"`
+	Duration    int64 `json:"duration"`
+}
+
+type apolloResolverTrace struct {
+	Path        []interface{} `json:"path"`
+	ParentType  string        `json:"parentType"`
+	FieldName   string        `json:"fieldName"`
+	ReturnType  string        `json:"returnType"`
+	StartOffset int64         `json:"startOffset"`
+	Duration    int64         `json:"duration"`
+}
+
+type apolloExecutionTrace struct {
+	Resolvers []apolloResolverTrace `json:"resolvers"`
+}
+
+type apolloTrace struct {
+	Version   int                  `json:"version"`
+	StartTime string               `json:"startTime"`
+	EndTime   string               `json:"endTime"`
+	Duration  int64                `json:"duration"`
+	Parsing   apolloTracingOffset  `json:"parsing"`
+	Validate  apolloTracingOffset  `json:"validation"`
+	Execution apolloExecutionTrace `json:"execution"`
+}
+
+type apolloTraceState struct {
+	mu        sync.Mutex
+	start     time.Time
+	parsing   apolloTracingOffset
+	validate  apolloTracingOffset
+	resolvers []apolloResolverTrace
+}
+
+type apolloTraceKey struct{}
+
+// ApolloTracingTracer is a Tracer that accumulates per-request timing data
+// in the documented Apollo Tracing v1 format and injects it into the
+// response under extensions.tracing.
+type ApolloTracingTracer struct{}
+
+// NewApolloTracingTracer constructs an ApolloTracingTracer.
+func NewApolloTracingTracer() *ApolloTracingTracer {
+	return &ApolloTracingTracer{}
+}
+
+func (t *ApolloTracingTracer) StartRequest(ctx context.Context, query string) (context.Context, func()) {
+	state := &apolloTraceState{start: time.Now()}
+	ctx = context.WithValue(ctx, apolloTraceKey{}, state)
+	return ctx, func() {}
+}
+
+func (t *ApolloTracingTracer) StartParse(ctx context.Context) func() {
+	state, ok := ctx.Value(apolloTraceKey{}).(*apolloTraceState)
+	if !ok {
+		return func() {}
+	}
+	offset := time.Since(state.start)
+	started := time.Now()
+	return func() {
+		state.mu.Lock()
+		state.parsing = apolloTracingOffset{StartOffset: offset.Nanoseconds(), Duration: time.Since(started).Nanoseconds()}
+		state.mu.Unlock()
+	}
+}
+
+func (t *ApolloTracingTracer) StartValidate(ctx context.Context) func() {
+	state, ok := ctx.Value(apolloTraceKey{}).(*apolloTraceState)
+	if !ok {
+		return func() {}
+	}
+	offset := time.Since(state.start)
+	started := time.Now()
+	return func() {
+		state.mu.Lock()
+		state.validate = apolloTracingOffset{StartOffset: offset.Nanoseconds(), Duration: time.Since(started).Nanoseconds()}
+		state.mu.Unlock()
+	}
+}
+
+func (t *ApolloTracingTracer) StartField(ctx context.Context, info ResolveInfo) func() {
+	state, ok := ctx.Value(apolloTraceKey{}).(*apolloTraceState)
+	if !ok {
+		return func() {}
+	}
+	offset := time.Since(state.start)
+	started := time.Now()
+	return func() {
+		trace := apolloResolverTrace{
+			Path:        info.Path,
+			ParentType:  info.ParentType,
+			FieldName:   info.FieldName,
+			ReturnType:  info.ReturnType,
+			StartOffset: offset.Nanoseconds(),
+			Duration:    time.Since(started).Nanoseconds(),
+		}
+		state.mu.Lock()
+		state.resolvers = append(state.resolvers, trace)
+		state.mu.Unlock()
+	}
+}
+
+func (t *ApolloTracingTracer) EndRequest(ctx context.Context, result *graphql.Result) {
+	state, ok := ctx.Value(apolloTraceKey{}).(*apolloTraceState)
+	if !ok {
+		return
+	}
+
+	end := time.Now()
+	state.mu.Lock()
+	trace := apolloTrace{
+		Version:   apolloTracingVersion,
+		StartTime: state.start.UTC().Format(time.RFC3339Nano),
+		EndTime:   end.UTC().Format(time.RFC3339Nano),
+		Duration:  end.Sub(state.start).Nanoseconds(),
+		Parsing:   state.parsing,
+		Validate:  state.validate,
+		Execution: apolloExecutionTrace{Resolvers: state.resolvers},
+	}
+	state.mu.Unlock()
+
+	if result.Extensions == nil {
+		result.Extensions = make(map[string]interface{})
+	}
+	result.Extensions["tracing"] = trace
+}
@@ -0,0 +1,120 @@
+package handler
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sync"
+
+	"github.com/bookreport/graphql"
+	"github.com/bookreport/graphql/gqlerrors"
+)
+
+// persistedQueryExtension is the "extensions.persistedQuery" object sent
+// by Apollo-style clients implementing Automatic Persisted Queries.
+type persistedQueryExtension struct {
+	Version    int    `json:"version"`
+	Sha256Hash string `json:"sha256Hash"`
+}
+
+type requestExtensions struct {
+	PersistedQuery *persistedQueryExtension `json:"persistedQuery"`
+}
+
+const errPersistedQueryNotFound = "PersistedQueryNotFound"
+
+// apqCache is a bounded, concurrency-safe LRU cache mapping a query's
+// sha256 hash to its source text.
+type apqCache struct {
+	mu       sync.Mutex
+	capacity int
+	entries  map[string]*list.Element
+	order    *list.List
+}
+
+type apqEntry struct {
+	hash  string
+	query string
+}
+
+func newAPQCache(capacity int) *apqCache {
+	if capacity <= 0 {
+		capacity = 1000
+	}
+	return &apqCache{
+		capacity: capacity,
+		entries:  make(map[string]*list.Element),
+		order:    list.New(),
+	}
+}
+
+func (c *apqCache) get(hash string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[hash]
+	if !ok {
+		return "", false
+	}
+	c.order.MoveToFront(el)
+	return el.Value.(*apqEntry).query, true
+}
+
+func (c *apqCache) set(hash, query string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.entries[hash]; ok {
+		el.Value.(*apqEntry).query = query
+		c.order.MoveToFront(el)
+		return
+	}
+
+	el := c.order.PushFront(&apqEntry{hash: hash, query: query})
+	c.entries[hash] = el
+
+	if c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		if oldest != nil {
+			c.order.Remove(oldest)
+			delete(c.entries, oldest.Value.(*apqEntry).hash)
+		}
+	}
+}
+
+// resolvePersistedQuery applies the Automatic Persisted Queries protocol
+// to opts in place: it fills in opts.Query from the cache when only a
+// hash is supplied, stores a freshly supplied query under its hash, and
+// returns a PersistedQueryNotFound error result when the hash is unknown.
+func resolvePersistedQuery(cache *apqCache, opts *RequestOptions, extensionsRaw json.RawMessage) *graphql.Result {
+	if cache == nil || len(extensionsRaw) == 0 {
+		return nil
+	}
+
+	var ext requestExtensions
+	if err := json.Unmarshal(extensionsRaw, &ext); err != nil || ext.PersistedQuery == nil {
+		return nil
+	}
+	hash := ext.PersistedQuery.Sha256Hash
+
+	if opts.Query == "" {
+		query, ok := cache.get(hash)
+		if !ok {
+			return &graphql.Result{
+				Errors: gqlerrors.FormatErrors(gqlerrors.NewFormattedError(errPersistedQueryNotFound)),
+			}
+		}
+		opts.Query = query
+		return nil
+	}
+
+	sum := sha256.Sum256([]byte(opts.Query))
+	if hex.EncodeToString(sum[:]) != hash {
+		return &graphql.Result{
+			Errors: gqlerrors.FormatErrors(gqlerrors.NewFormattedError("provided sha256Hash does not match query")),
+		}
+	}
+	cache.set(hash, opts.Query)
+	return nil
+}
@@ -0,0 +1,113 @@
+package handler
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+)
+
+func sha256Hex(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestAPQCacheGetSet(t *testing.T) {
+	cache := newAPQCache(2)
+
+	if _, ok := cache.get("missing"); ok {
+		t.Fatalf("get on empty cache should miss")
+	}
+
+	cache.set("a", "{ a }")
+	query, ok := cache.get("a")
+	if !ok || query != "{ a }" {
+		t.Fatalf("get(%q) = %q, %v; want %q, true", "a", query, ok, "{ a }")
+	}
+}
+
+func TestAPQCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	cache := newAPQCache(2)
+
+	cache.set("a", "{ a }")
+	cache.set("b", "{ b }")
+	cache.set("c", "{ c }") // evicts "a": capacity is 2, "a" is least recently used
+
+	if _, ok := cache.get("a"); ok {
+		t.Fatalf("expected %q to be evicted", "a")
+	}
+	if _, ok := cache.get("b"); !ok {
+		t.Fatalf("expected %q to still be cached", "b")
+	}
+	if _, ok := cache.get("c"); !ok {
+		t.Fatalf("expected %q to still be cached", "c")
+	}
+}
+
+func TestAPQCacheGetRefreshesRecency(t *testing.T) {
+	cache := newAPQCache(2)
+
+	cache.set("a", "{ a }")
+	cache.set("b", "{ b }")
+	cache.get("a")          // touch "a" so "b" becomes least recently used
+	cache.set("c", "{ c }") // evicts "b", not "a"
+
+	if _, ok := cache.get("b"); ok {
+		t.Fatalf("expected %q to be evicted", "b")
+	}
+	if _, ok := cache.get("a"); !ok {
+		t.Fatalf("expected %q to still be cached after being refreshed", "a")
+	}
+}
+
+func TestAPQCacheDefaultsCapacity(t *testing.T) {
+	cache := newAPQCache(0)
+	if cache.capacity != 1000 {
+		t.Fatalf("capacity = %d; want default of 1000", cache.capacity)
+	}
+}
+
+func TestResolvePersistedQueryNotFound(t *testing.T) {
+	cache := newAPQCache(10)
+	opts := &RequestOptions{}
+	extensions := []byte(`{"persistedQuery":{"version":1,"sha256Hash":"deadbeef"}}`)
+
+	result := resolvePersistedQuery(cache, opts, extensions)
+	if result == nil || len(result.Errors) == 0 {
+		t.Fatalf("expected a PersistedQueryNotFound error, got %+v", result)
+	}
+	if result.Errors[0].Message != errPersistedQueryNotFound {
+		t.Fatalf("error = %q; want %q", result.Errors[0].Message, errPersistedQueryNotFound)
+	}
+}
+
+func TestResolvePersistedQueryStoresAndReplays(t *testing.T) {
+	cache := newAPQCache(10)
+	query := "{ hello }"
+	hash := sha256Hex(query)
+
+	store := &RequestOptions{Query: query, Extensions: []byte(`{"persistedQuery":{"version":1,"sha256Hash":"` + hash + `"}}`)}
+	if result := resolvePersistedQuery(cache, store, store.Extensions); result != nil {
+		t.Fatalf("storing a query with a matching hash should not error, got %+v", result)
+	}
+
+	replay := &RequestOptions{Extensions: []byte(`{"persistedQuery":{"version":1,"sha256Hash":"` + hash + `"}}`)}
+	if result := resolvePersistedQuery(cache, replay, replay.Extensions); result != nil {
+		t.Fatalf("replaying a stored hash should not error, got %+v", result)
+	}
+	if replay.Query != query {
+		t.Fatalf("replay.Query = %q; want %q", replay.Query, query)
+	}
+}
+
+func TestResolvePersistedQueryHashMismatch(t *testing.T) {
+	cache := newAPQCache(10)
+	opts := &RequestOptions{
+		Query:      "{ hello }",
+		Extensions: []byte(`{"persistedQuery":{"version":1,"sha256Hash":"wrong"}}`),
+	}
+
+	result := resolvePersistedQuery(cache, opts, opts.Extensions)
+	if result == nil || len(result.Errors) == 0 {
+		t.Fatalf("expected a hash-mismatch error, got %+v", result)
+	}
+}
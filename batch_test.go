@@ -0,0 +1,171 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bookreport/graphql"
+	"golang.org/x/net/context"
+)
+
+func newBatchTestHandler(t *testing.T, workerLimit int, resolve graphql.FieldResolveFn) *Handler {
+	t.Helper()
+
+	query := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Query",
+		Fields: graphql.Fields{
+			"echo": &graphql.Field{
+				Type: graphql.String,
+				Args: graphql.FieldConfigArgument{
+					"value": &graphql.ArgumentConfig{Type: graphql.String},
+				},
+				Resolve: resolve,
+			},
+		},
+	})
+
+	schema, err := graphql.NewSchema(graphql.SchemaConfig{Query: query})
+	if err != nil {
+		t.Fatalf("NewSchema: %v", err)
+	}
+
+	cfg := NewConfig()
+	cfg.Schema = &schema
+	cfg.BatchingEnabled = true
+	cfg.BatchWorkerLimit = workerLimit
+	return New(cfg)
+}
+
+func TestServeBatchPreservesOrder(t *testing.T) {
+	h := newBatchTestHandler(t, 10, func(p graphql.ResolveParams) (interface{}, error) {
+		n, _ := strconv.Atoi(p.Args["value"].(string))
+		// Resolve out of order (later entries finish first) to prove the
+		// response array is reordered back to request order, not
+		// completion order.
+		time.Sleep(time.Duration(4-n) * 5 * time.Millisecond)
+		return p.Args["value"], nil
+	})
+
+	body := `[{"query":"{ echo(value: \"0\") }"},{"query":"{ echo(value: \"1\") }"},{"query":"{ echo(value: \"2\") }"},{"query":"{ echo(value: \"3\") }"}]`
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("POST", "/graphql", strings.NewReader(body))
+	r.Header.Set("Content-Type", ContentTypeJSON)
+
+	h.ContextHandler(context.Background(), w, r)
+
+	var results []struct {
+		Data struct {
+			Echo string `json:"echo"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &results); err != nil {
+		t.Fatalf("unmarshal response: %v (body=%s)", err, w.Body.String())
+	}
+	if len(results) != 4 {
+		t.Fatalf("got %d results; want 4", len(results))
+	}
+	for i, res := range results {
+		want := strconv.Itoa(i)
+		if res.Data.Echo != want {
+			t.Fatalf("results[%d].data.echo = %q; want %q (batch results must stay in request order)", i, res.Data.Echo, want)
+		}
+	}
+}
+
+func TestServeBatchRespectsWorkerLimit(t *testing.T) {
+	const limit = 2
+	var current int32
+	var maxObserved int32
+	var mu sync.Mutex
+	release := make(chan struct{})
+
+	h := newBatchTestHandler(t, limit, func(p graphql.ResolveParams) (interface{}, error) {
+		n := atomic.AddInt32(&current, 1)
+		mu.Lock()
+		if n > maxObserved {
+			maxObserved = n
+		}
+		mu.Unlock()
+		<-release
+		atomic.AddInt32(&current, -1)
+		return "ok", nil
+	})
+
+	var ops []string
+	for i := 0; i < 6; i++ {
+		ops = append(ops, `{"query":"{ echo(value: \"x\") }"}`)
+	}
+	body := "[" + strings.Join(ops, ",") + "]"
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("POST", "/graphql", strings.NewReader(body))
+	r.Header.Set("Content-Type", ContentTypeJSON)
+
+	done := make(chan struct{})
+	go func() {
+		h.ContextHandler(context.Background(), w, r)
+		close(done)
+	}()
+
+	// Give every worker slot a chance to fill, then release them all.
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	<-done
+
+	mu.Lock()
+	defer mu.Unlock()
+	if maxObserved > limit {
+		t.Fatalf("observed %d concurrent batch resolvers; BatchWorkerLimit was %d", maxObserved, limit)
+	}
+}
+
+func TestServeBatchRejectsOversizedBatch(t *testing.T) {
+	h := newBatchTestHandler(t, 10, func(p graphql.ResolveParams) (interface{}, error) {
+		return "ok", nil
+	})
+	h.MaxBatchSize = 1
+
+	body := `[{"query":"{ echo(value: \"a\") }"},{"query":"{ echo(value: \"b\") }"}]`
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("POST", "/graphql", strings.NewReader(body))
+	r.Header.Set("Content-Type", ContentTypeJSON)
+
+	h.ContextHandler(context.Background(), w, r)
+
+	if w.Code != 400 {
+		t.Fatalf("status = %d; want 400 for a batch over MaxBatchSize", w.Code)
+	}
+}
+
+func TestServeBatchMalformedEntryDoesNotFailWholeBatch(t *testing.T) {
+	h := newBatchTestHandler(t, 10, func(p graphql.ResolveParams) (interface{}, error) {
+		return p.Args["value"], nil
+	})
+
+	body := `[{"query":"{ echo(value: \"ok\") }"}, "not an operation"]`
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("POST", "/graphql", strings.NewReader(body))
+	r.Header.Set("Content-Type", ContentTypeJSON)
+
+	h.ContextHandler(context.Background(), w, r)
+
+	var results []graphql.Result
+	if err := json.Unmarshal(w.Body.Bytes(), &results); err != nil {
+		t.Fatalf("unmarshal response: %v (body=%s)", err, w.Body.String())
+	}
+	if len(results) != 2 {
+		t.Fatalf("got %d results; want 2", len(results))
+	}
+	if len(results[0].Errors) != 0 {
+		t.Fatalf("results[0] should have succeeded, got errors: %+v", results[0].Errors)
+	}
+	if len(results[1].Errors) == 0 {
+		t.Fatalf("results[1] should report the malformed-operation error")
+	}
+}
@@ -0,0 +1,135 @@
+package handler
+
+import (
+	"github.com/bookreport/graphql"
+	"github.com/bookreport/graphql/gqlerrors"
+	"github.com/bookreport/graphql/language/ast"
+)
+
+// analyzeComplexity walks doc's operation/fragment selection sets and
+// returns the query's field-count complexity score and its maximum
+// selection depth. Each field is worth 1 point by default; a field
+// carrying a "complexity" directive with an Int "value" argument
+// overrides that field's contribution.
+func analyzeComplexity(doc *ast.Document) (complexity int, depth int) {
+	fragments := make(map[string]*ast.FragmentDefinition)
+	for _, def := range doc.Definitions {
+		if frag, ok := def.(*ast.FragmentDefinition); ok {
+			fragments[frag.Name.Value] = frag
+		}
+	}
+
+	for _, def := range doc.Definitions {
+		op, ok := def.(*ast.OperationDefinition)
+		if !ok || op.SelectionSet == nil {
+			continue
+		}
+		c, d := walkSelectionSet(op.SelectionSet, fragments, 1, make(map[string]bool))
+		complexity += c
+		if d > depth {
+			depth = d
+		}
+	}
+	return complexity, depth
+}
+
+func walkSelectionSet(set *ast.SelectionSet, fragments map[string]*ast.FragmentDefinition, level int, visiting map[string]bool) (int, int) {
+	complexity := 0
+	maxDepth := level
+
+	for _, sel := range set.Selections {
+		switch s := sel.(type) {
+		case *ast.Field:
+			complexity += fieldComplexity(s)
+			if s.SelectionSet != nil {
+				c, d := walkSelectionSet(s.SelectionSet, fragments, level+1, visiting)
+				complexity += c
+				if d > maxDepth {
+					maxDepth = d
+				}
+			}
+		case *ast.InlineFragment:
+			if s.SelectionSet != nil {
+				c, d := walkSelectionSet(s.SelectionSet, fragments, level, visiting)
+				complexity += c
+				if d > maxDepth {
+					maxDepth = d
+				}
+			}
+		case *ast.FragmentSpread:
+			name := s.Name.Value
+			if visiting[name] {
+				continue
+			}
+			frag, ok := fragments[name]
+			if !ok || frag.SelectionSet == nil {
+				continue
+			}
+			visiting[name] = true
+			c, d := walkSelectionSet(frag.SelectionSet, fragments, level, visiting)
+			delete(visiting, name)
+			complexity += c
+			if d > maxDepth {
+				maxDepth = d
+			}
+		}
+	}
+
+	return complexity, maxDepth
+}
+
+func fieldComplexity(f *ast.Field) int {
+	for _, dir := range f.Directives {
+		if dir.Name == nil || dir.Name.Value != "complexity" {
+			continue
+		}
+		for _, arg := range dir.Arguments {
+			if arg.Name == nil || arg.Name.Value != "value" {
+				continue
+			}
+			if iv, ok := arg.Value.(*ast.IntValue); ok {
+				var n int
+				for _, ch := range iv.Value {
+					if ch < '0' || ch > '9' {
+						return 1
+					}
+					n = n*10 + int(ch-'0')
+				}
+				return n
+			}
+		}
+	}
+	return 1
+}
+
+// checkComplexity parses query and rejects it with a standard GraphQL
+// error if its complexity or depth exceeds the configured limits. A zero
+// limit disables the corresponding check. The parsed document is returned
+// so callers don't need to re-parse it.
+func checkComplexity(query string, complexityLimit, maxDepth int) (*ast.Document, *graphql.Result) {
+	doc, err := graphql.Parse(graphql.ParseParams{Source: query})
+	if err != nil {
+		return nil, &graphql.Result{
+			Errors: gqlerrors.FormatErrors(err),
+		}
+	}
+
+	if complexityLimit <= 0 && maxDepth <= 0 {
+		return doc, nil
+	}
+
+	complexity, depth := analyzeComplexity(doc)
+
+	if complexityLimit > 0 && complexity > complexityLimit {
+		return doc, &graphql.Result{
+			Errors: gqlerrors.FormatErrors(gqlerrors.NewFormattedError("query exceeds complexity limit")),
+		}
+	}
+	if maxDepth > 0 && depth > maxDepth {
+		return doc, &graphql.Result{
+			Errors: gqlerrors.FormatErrors(gqlerrors.NewFormattedError("query exceeds maximum depth")),
+		}
+	}
+
+	return doc, nil
+}
@@ -0,0 +1,111 @@
+package handler
+
+import (
+	"testing"
+
+	"github.com/bookreport/graphql"
+	"github.com/bookreport/graphql/language/ast"
+)
+
+func mustParse(t *testing.T, query string) *ast.Document {
+	t.Helper()
+	doc, err := graphql.Parse(graphql.ParseParams{Source: query})
+	if err != nil {
+		t.Fatalf("Parse(%q): %v", query, err)
+	}
+	return doc
+}
+
+func TestAnalyzeComplexityCountsFields(t *testing.T) {
+	doc := mustParse(t, `{ a b c { d e } }`)
+
+	complexity, depth := analyzeComplexity(doc)
+	if complexity != 5 {
+		t.Fatalf("complexity = %d; want 5", complexity)
+	}
+	if depth != 2 {
+		t.Fatalf("depth = %d; want 2", depth)
+	}
+}
+
+func TestAnalyzeComplexityHonorsDirective(t *testing.T) {
+	doc := mustParse(t, `{ a b(first: 10) @complexity(value: 10) }`)
+
+	complexity, _ := analyzeComplexity(doc)
+	if complexity != 11 {
+		t.Fatalf("complexity = %d; want 11 (1 for a, 10 for b)", complexity)
+	}
+}
+
+func TestAnalyzeComplexityFollowsFragments(t *testing.T) {
+	doc := mustParse(t, `
+		{ a ...Frag }
+		fragment Frag on Query { b c { d } }
+	`)
+
+	complexity, depth := analyzeComplexity(doc)
+	if complexity != 4 {
+		t.Fatalf("complexity = %d; want 4", complexity)
+	}
+	if depth != 2 {
+		t.Fatalf("depth = %d; want 2", depth)
+	}
+}
+
+func TestAnalyzeComplexityIgnoresFragmentCycles(t *testing.T) {
+	doc := mustParse(t, `
+		{ ...A }
+		fragment A on Query { a ...B }
+		fragment B on Query { b ...A }
+	`)
+
+	// Should terminate (not infinitely recurse) and count each fragment once.
+	complexity, _ := analyzeComplexity(doc)
+	if complexity != 2 {
+		t.Fatalf("complexity = %d; want 2 (a + b, A/B visited once each)", complexity)
+	}
+}
+
+func TestCheckComplexityRejectsOverLimit(t *testing.T) {
+	_, result := checkComplexity(`{ a b c }`, 2, 0)
+	if result == nil {
+		t.Fatalf("expected a complexity-limit error")
+	}
+}
+
+func TestCheckComplexityRejectsOverDepth(t *testing.T) {
+	_, result := checkComplexity(`{ a { b { c } } }`, 0, 2)
+	if result == nil {
+		t.Fatalf("expected a max-depth error")
+	}
+}
+
+func TestCheckComplexityReturnsParsedDocOnSuccess(t *testing.T) {
+	doc, result := checkComplexity(`{ a b }`, 10, 10)
+	if result != nil {
+		t.Fatalf("expected no error, got %+v", result)
+	}
+	if doc == nil {
+		t.Fatalf("expected the parsed document to be returned")
+	}
+}
+
+func TestCheckComplexityRejectsMalformedQuery(t *testing.T) {
+	doc, result := checkComplexity(`{ a `, 10, 10)
+	if result == nil {
+		t.Fatalf("expected a parse error for a malformed query")
+	}
+	if doc != nil {
+		t.Fatalf("expected no document for a malformed query")
+	}
+}
+
+func TestCheckComplexityDisabledReturnsDoc(t *testing.T) {
+	doc, result := checkComplexity(`{ a b c d e f g }`, 0, 0)
+	if result != nil {
+		t.Fatalf("expected no error when both limits are disabled, got %+v", result)
+	}
+	if doc == nil {
+		t.Fatalf("expected the parsed document to be returned")
+	}
+}
@@ -0,0 +1,141 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/bookreport/graphql"
+	"github.com/bookreport/graphql/gqlerrors"
+	"golang.org/x/net/context"
+)
+
+// ErrorPresenterFn lets callers redact, annotate, or attach extensions
+// (an error code, a stack trace in dev mode, ...) to every error before
+// it is serialized in the response.
+type ErrorPresenterFn func(ctx context.Context, err gqlerrors.FormattedError) gqlerrors.FormattedError
+
+// RecoverFunc turns a recovered resolver panic into a well-formed
+// GraphQL error instead of letting it crash the serving goroutine.
+type RecoverFunc func(ctx context.Context, recovered interface{}) gqlerrors.FormattedError
+
+// DefaultErrorPresenter passes errors through unchanged.
+func DefaultErrorPresenter(ctx context.Context, err gqlerrors.FormattedError) gqlerrors.FormattedError {
+	return err
+}
+
+// DefaultRecoverFunc reports the recovered value as an opaque internal
+// error, without leaking any detail about the panic to the client. Set
+// Config.Debug to use debugRecoverFunc instead, which includes the
+// recovered value in the message.
+func DefaultRecoverFunc(ctx context.Context, recovered interface{}) gqlerrors.FormattedError {
+	return gqlerrors.NewFormattedError("internal server error")
+}
+
+// debugRecoverFunc is used in place of DefaultRecoverFunc when
+// Config.Debug is set, to aid local development; it is never the
+// out-of-the-box default because the recovered value may contain
+// internal state that shouldn't reach API clients.
+func debugRecoverFunc(ctx context.Context, recovered interface{}) gqlerrors.FormattedError {
+	return gqlerrors.NewFormattedError(fmt.Sprintf("internal server error: %v", recovered))
+}
+
+func isSupportedContentType(contentType string) bool {
+	switch contentType {
+	case "", ContentTypeJSON, ContentTypeGraphQL, ContentTypeFormURLEncoded:
+		return true
+	}
+	return false
+}
+
+// checkMethodAndContentType enforces the request-level preconditions this
+// handler supports, writing a GraphQL error envelope with the appropriate
+// status code and returning false if one is violated.
+func (h *Handler) checkMethodAndContentType(ctx context.Context, w http.ResponseWriter, r *http.Request) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		h.writeStatusError(ctx, w, http.StatusMethodNotAllowed, "method not allowed")
+		return false
+	}
+
+	if r.Method == http.MethodPost {
+		contentType := strings.Split(r.Header.Get("Content-Type"), ";")[0]
+		if !isSupportedContentType(contentType) {
+			h.writeStatusError(ctx, w, http.StatusUnsupportedMediaType, "unsupported content type: "+contentType)
+			return false
+		}
+	}
+
+	return true
+}
+
+// enforceBodyLimit rejects POST bodies larger than Config.MaxBodyBytes
+// with a 413, buffering the (size-checked) body back onto r.Body so
+// downstream reads see it exactly once.
+func (h *Handler) enforceBodyLimit(ctx context.Context, w http.ResponseWriter, r *http.Request) bool {
+	if h.MaxBodyBytes <= 0 || r.Body == nil || r.Method != http.MethodPost {
+		return true
+	}
+
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, h.MaxBodyBytes))
+	if err != nil {
+		h.writeStatusError(ctx, w, http.StatusRequestEntityTooLarge, "request body too large")
+		return false
+	}
+
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	return true
+}
+
+// writeStatusError writes a single-error GraphQL JSON envelope with the
+// given HTTP status code, running the error through Config.ErrorPresenter.
+func (h *Handler) writeStatusError(ctx context.Context, w http.ResponseWriter, status int, message string) {
+	result := &graphql.Result{
+		Errors: []gqlerrors.FormattedError{gqlerrors.NewFormattedError(message)},
+	}
+	h.writeResultWithStatus(ctx, w, status, result)
+}
+
+// presentResult runs every error in result through Config.ErrorPresenter.
+func (h *Handler) presentResult(ctx context.Context, result *graphql.Result) *graphql.Result {
+	if len(result.Errors) == 0 || h.ErrorPresenter == nil {
+		return result
+	}
+
+	presented := make([]gqlerrors.FormattedError, len(result.Errors))
+	for i, err := range result.Errors {
+		presented[i] = h.ErrorPresenter(ctx, err)
+	}
+	result.Errors = presented
+	return result
+}
+
+// writeResultWithStatus serializes result as the GraphQL JSON envelope
+// under the given HTTP status, honoring Handler.Pretty.
+func (h *Handler) writeResultWithStatus(ctx context.Context, w http.ResponseWriter, status int, result *graphql.Result) {
+	result = h.presentResult(ctx, result)
+
+	w.WriteHeader(status)
+	if h.Pretty {
+		buff, _ := json.MarshalIndent(result, "", "\t")
+		w.Write(buff)
+	} else {
+		buff, _ := json.Marshal(result)
+		w.Write(buff)
+	}
+}
+
+// recoverAsResult converts a recovered resolver panic into a GraphQL
+// error result via Config.RecoverFunc. Call it from a deferred function.
+func (h *Handler) recoverAsResult(ctx context.Context, recovered interface{}) *graphql.Result {
+	fn := h.RecoverFunc
+	if fn == nil {
+		fn = DefaultRecoverFunc
+		if h.Debug {
+			fn = debugRecoverFunc
+		}
+	}
+	return &graphql.Result{Errors: []gqlerrors.FormattedError{fn(ctx, recovered)}}
+}
@@ -0,0 +1,57 @@
+package handler
+
+import (
+	"embed"
+	"html/template"
+	"net/http"
+	"strings"
+)
+
+//go:embed static/graphiql.html static/playground.html
+var uiAssets embed.FS
+
+var graphiQLTemplate = template.Must(template.ParseFS(uiAssets, "static/graphiql.html"))
+var playgroundTemplate = template.Must(template.ParseFS(uiAssets, "static/playground.html"))
+
+type uiPageData struct {
+	Endpoint        string
+	SubscriptionURL string
+	Authorization   string
+}
+
+// acceptsHTML reports whether r's Accept header lists text/html at all;
+// the order of the entries and their q-values are not considered.
+func acceptsHTML(r *http.Request) bool {
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		if strings.HasPrefix(strings.TrimSpace(accept), "text/html") {
+			return true
+		}
+	}
+	return false
+}
+
+// serveUI renders GraphiQL or GraphQL Playground (per Config.GraphiQL /
+// Config.Playground) pointed at r's own URL as the GraphQL endpoint.
+func (h *Handler) serveUI(w http.ResponseWriter, r *http.Request) bool {
+	if r.Method != http.MethodGet || !acceptsHTML(r) {
+		return false
+	}
+	if !h.GraphiQL && !h.Playground {
+		return false
+	}
+
+	data := uiPageData{
+		Endpoint:        r.URL.Path,
+		SubscriptionURL: h.SubscriptionURL,
+		Authorization:   r.Header.Get("Authorization"),
+	}
+
+	tmpl := graphiQLTemplate
+	if h.Playground {
+		tmpl = playgroundTemplate
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	tmpl.Execute(w, data)
+	return true
+}
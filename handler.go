@@ -10,6 +10,9 @@ import (
 	"time"
 
 	"github.com/bookreport/graphql"
+	"github.com/bookreport/graphql/gqlerrors"
+	"github.com/bookreport/graphql/language/ast"
+	"github.com/gorilla/websocket"
 	"golang.org/x/net/context"
 )
 
@@ -24,11 +27,41 @@ type Handler struct {
 	Pretty        bool
 	BeforeRequest RequestCallbackFn
 	AfterRequest  RequestCallbackFn
+
+	Upgrader              *websocket.Upgrader
+	KeepAliveInterval     time.Duration
+	ConnectionInitTimeout time.Duration
+	WebSocketAuthFunc     WebSocketAuthFn
+
+	GraphiQL        bool
+	Playground      bool
+	SubscriptionURL string
+
+	ComplexityLimit int
+	MaxDepth        int
+	APQEnabled      bool
+	apqCache        *apqCache
+
+	BatchingEnabled  bool
+	MaxBatchSize     int
+	BatchWorkerLimit int
+
+	tracer Tracer
+
+	ErrorPresenter ErrorPresenterFn
+	RecoverFunc    RecoverFunc
+	MaxBodyBytes   int64
+	// Debug makes the default RecoverFunc include the recovered panic
+	// value in the error message sent to clients. Leave false in
+	// production; a panic value can easily contain internal state that
+	// shouldn't reach API clients. Has no effect when RecoverFunc is set.
+	Debug bool
 }
 type RequestOptions struct {
 	Query         string                 `json:"query" url:"query" schema:"query"`
 	Variables     map[string]interface{} `json:"variables" url:"variables" schema:"variables"`
 	OperationName string                 `json:"operationName" url:"operationName" schema:"operationName"`
+	Extensions    json.RawMessage        `json:"extensions" url:"extensions" schema:"extensions"`
 }
 
 // a workaround for getting`variables` as a JSON string
@@ -40,7 +73,8 @@ type requestOptionsCompatibility struct {
 
 func getFromForm(values url.Values) *RequestOptions {
 	query := values.Get("query")
-	if query != "" {
+	extensions := values.Get("extensions")
+	if query != "" || extensions != "" {
 		// get variables map
 		var variables map[string]interface{}
 		variablesStr := values.Get("variables")
@@ -50,6 +84,7 @@ func getFromForm(values url.Values) *RequestOptions {
 			Query:         query,
 			Variables:     variables,
 			OperationName: values.Get("operationName"),
+			Extensions:    json.RawMessage(extensions),
 		}
 	}
 
@@ -118,49 +153,133 @@ func NewRequestOptions(r *http.Request) *RequestOptions {
 // ContextHandler provides an entrypoint into executing graphQL queries with a
 // user-provided context.
 func (h *Handler) ContextHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) {
-	start := time.Now()
+	if isWebSocketUpgrade(r) {
+		h.ServeWebSocket(ctx, w, r)
+		return
+	}
+
+	if h.serveUI(w, r) {
+		return
+	}
+
+	if !h.checkMethodAndContentType(ctx, w, r) {
+		return
+	}
+	if !h.enforceBodyLimit(ctx, w, r) {
+		return
+	}
+
+	if h.BatchingEnabled && r.Method == "POST" {
+		if body := readBatchableBody(r); isBatchRequest(body) {
+			h.serveBatch(ctx, w, r, body)
+			return
+		}
+	}
 
 	// get query and app location
 	opts := NewRequestOptions(r)
-	appLocation := r.Header.Get("X-App-Location")
 
 	// send the authorization header with the root object
 	root := make(map[string]interface{})
 	root["Authorization"] = r.Header.Get("Authorization")
 
+	result, status := h.execute(ctx, r, opts, root)
+
+	if result.HasErrors() {
+		for _, err := range result.Errors {
+			log.Println(err.LocalizedStackTrace)
+		}
+	}
+
+	h.writeResultWithStatus(ctx, w, status, result)
+}
+
+// execute is the single pipeline every operation runs through, whether it
+// arrives as the top-level request or as one entry of a batch: it resolves
+// Automatic Persisted Queries, enforces ComplexityLimit/MaxDepth, parses
+// (reusing the document already produced by the complexity check where
+// possible), validates, and runs opts.Query, recovering any resolver panic
+// into a GraphQL error via Config.RecoverFunc. It returns the HTTP status
+// the response should carry: 400 for parse/validate failures and for
+// queries over ComplexityLimit/MaxDepth, 200 otherwise (including
+// resolver-level errors, which are a normal part of the GraphQL response
+// envelope).
+func (h *Handler) execute(ctx context.Context, r *http.Request, opts *RequestOptions, root map[string]interface{}) (result *graphql.Result, status int) {
+	status = http.StatusOK
+
+	if h.APQEnabled {
+		if res := resolvePersistedQuery(h.apqCache, opts, opts.Extensions); res != nil {
+			return res, status
+		}
+	}
+
+	var doc *ast.Document
+	if h.ComplexityLimit > 0 || h.MaxDepth > 0 {
+		parsed, res := checkComplexity(opts.Query, h.ComplexityLimit, h.MaxDepth)
+		if res != nil {
+			// Malformed query and limit-exceeded are both client errors,
+			// same as the parse/validate failures handled below - don't
+			// let the status code for an identical bad request depend on
+			// whether complexity limiting happens to be configured.
+			return res, http.StatusBadRequest
+		}
+		doc = parsed
+	}
+
+	appLocation := r.Header.Get("X-App-Location")
 	authToken := r.Header.Get("Authorization")
 	requestID := time.Now().UnixNano()
 	h.BeforeRequest(0, appLocation, opts.Query, authToken, requestID)
+	start := time.Now()
 
-	// execute graphql query
-	params := graphql.Params{
-		Schema:         *h.Schema,
-		RequestString:  opts.Query,
-		VariableValues: opts.Variables,
-		OperationName:  opts.OperationName,
-		Context:        ctx,
-		RootObject:     root,
-	}
+	defer func() {
+		h.AfterRequest(time.Since(start), appLocation, opts.Query, authToken, requestID)
+	}()
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			result = h.recoverAsResult(ctx, recovered)
+		}
+	}()
 
-	result := graphql.Do(params)
-	if result.HasErrors() {
-		for _, err := range result.Errors {
-			log.Println(err.LocalizedStackTrace)
+	tracedCtx, endRequest := h.tracer.StartRequest(extractIncomingSpan(ctx, r), opts.Query)
+	defer endRequest()
+	tracedCtx = withTracer(tracedCtx, h.tracer)
+
+	if doc == nil {
+		endParse := h.tracer.StartParse(tracedCtx)
+		parsed, parseErr := graphql.Parse(graphql.ParseParams{Source: opts.Query})
+		endParse()
+
+		if parseErr != nil {
+			result = &graphql.Result{Errors: gqlerrors.FormatErrors(parseErr)}
+			status = http.StatusBadRequest
+			h.tracer.EndRequest(tracedCtx, result)
+			return result, status
 		}
+		doc = parsed
 	}
 
-	if h.Pretty {
-		w.WriteHeader(http.StatusOK)
-		buff, _ := json.MarshalIndent(result, "", "\t")
-		w.Write(buff)
-	} else {
-		w.WriteHeader(http.StatusOK)
-		buff, _ := json.Marshal(result)
-		w.Write(buff)
+	endValidate := h.tracer.StartValidate(tracedCtx)
+	validation := graphql.ValidateDocument(h.Schema, doc, nil)
+	endValidate()
+
+	if !validation.IsValid {
+		result = &graphql.Result{Errors: validation.Errors}
+		status = http.StatusBadRequest
+		h.tracer.EndRequest(tracedCtx, result)
+		return result, status
 	}
 
-	elapsed := time.Since(start)
-	h.AfterRequest(elapsed, appLocation, opts.Query, authToken, requestID)
+	result = graphql.Do(graphql.Params{
+		Schema:         *h.Schema,
+		RequestString:  opts.Query,
+		VariableValues: opts.Variables,
+		OperationName:  opts.OperationName,
+		Context:        tracedCtx,
+		RootObject:     root,
+	})
+	h.tracer.EndRequest(tracedCtx, result)
+	return result, status
 }
 
 // ServeHTTP provides an entrypoint into executing graphQL queries.
@@ -175,6 +294,73 @@ type Config struct {
 	Pretty        bool
 	BeforeRequest RequestCallbackFn
 	AfterRequest  RequestCallbackFn
+
+	// Upgrader configures the WebSocket upgrade performed for subscription
+	// requests. It is only consulted when the incoming request negotiates
+	// the "graphql-ws" or "graphql-transport-ws" sub-protocol.
+	Upgrader *websocket.Upgrader
+	// KeepAliveInterval controls how often a keep-alive message is sent to
+	// subscription clients. Zero disables keep-alives.
+	KeepAliveInterval time.Duration
+	// ConnectionInitTimeout bounds how long we wait for a connection_init
+	// message before closing the socket. Defaults to 10 seconds.
+	ConnectionInitTimeout time.Duration
+	// WebSocketAuthFunc is invoked with the payload of the connection_init
+	// message and returns a context to use for every subscription started
+	// on that connection (e.g. after validating an auth token).
+	WebSocketAuthFunc WebSocketAuthFn
+
+	// GraphiQL serves the bundled GraphiQL UI on GET requests that accept
+	// text/html. Ignored when Playground is also set.
+	GraphiQL bool
+	// Playground serves the bundled GraphQL Playground UI instead of
+	// GraphiQL on GET requests that accept text/html.
+	Playground bool
+	// SubscriptionURL is the WebSocket URL the bundled UI should connect
+	// to for subscriptions; leave empty to disable subscriptions in the UI.
+	SubscriptionURL string
+
+	// ComplexityLimit rejects queries whose field count exceeds this
+	// value. Zero disables the check.
+	ComplexityLimit int
+	// MaxDepth rejects queries whose selection set nests deeper than
+	// this value. Zero disables the check.
+	MaxDepth int
+	// APQEnabled turns on Apollo-style Automatic Persisted Queries.
+	APQEnabled bool
+	// APQCacheSize bounds the number of persisted queries kept in
+	// memory. Defaults to 1000.
+	APQCacheSize int
+
+	// BatchingEnabled allows a POST body to be a JSON array of operations,
+	// each executed independently and returned in the same order.
+	BatchingEnabled bool
+	// MaxBatchSize rejects batches larger than this with a 400. Zero
+	// disables the check.
+	MaxBatchSize int
+	// BatchWorkerLimit bounds how many batch entries run concurrently.
+	// Defaults to 10.
+	BatchWorkerLimit int
+
+	// Tracers is an ordered chain of request tracers. Each hook on each
+	// tracer fires, in order, wrapping parsing, validation, and execution;
+	// parsing is not traced when ComplexityLimit or MaxDepth is set.
+	Tracers []Tracer
+
+	// ErrorPresenter redacts, annotates, or attaches extensions to every
+	// error before it is serialized in the response.
+	ErrorPresenter ErrorPresenterFn
+	// RecoverFunc turns a recovered resolver panic into a GraphQL error
+	// instead of crashing the serving goroutine.
+	RecoverFunc RecoverFunc
+	// MaxBodyBytes rejects POST bodies larger than this with a 413. Zero
+	// disables the check.
+	MaxBodyBytes int64
+	// Debug makes the default RecoverFunc include the recovered panic
+	// value in the error message sent to clients. Leave false in
+	// production; a panic value can easily contain internal state that
+	// shouldn't reach API clients. Has no effect when RecoverFunc is set.
+	Debug bool
 }
 
 func NewConfig() *Config {
@@ -183,6 +369,37 @@ func NewConfig() *Config {
 		Pretty:        true,
 		BeforeRequest: func(elapsed time.Duration, url, query, token string, id int64) {},
 		AfterRequest:  func(elapsed time.Duration, url, query, token string, id int64) {},
+
+		Upgrader: &websocket.Upgrader{
+			ReadBufferSize:  1024,
+			WriteBufferSize: 1024,
+			CheckOrigin:     func(r *http.Request) bool { return true },
+		},
+		KeepAliveInterval:     25 * time.Second,
+		ConnectionInitTimeout: 10 * time.Second,
+		WebSocketAuthFunc: func(ctx context.Context, initPayload json.RawMessage) (context.Context, error) {
+			return ctx, nil
+		},
+
+		GraphiQL:        false,
+		Playground:      false,
+		SubscriptionURL: "",
+
+		ComplexityLimit: 0,
+		MaxDepth:        0,
+		APQEnabled:      false,
+		APQCacheSize:    1000,
+
+		BatchingEnabled:  false,
+		MaxBatchSize:     0,
+		BatchWorkerLimit: 10,
+
+		Tracers: nil,
+
+		ErrorPresenter: DefaultErrorPresenter,
+		RecoverFunc:    nil,
+		MaxBodyBytes:   0,
+		Debug:          false,
 	}
 }
 
@@ -193,11 +410,37 @@ func New(p *Config) *Handler {
 	if p.Schema == nil {
 		panic("undefined GraphQL schema")
 	}
+	instrumentSchema(p.Schema)
 
 	return &Handler{
 		Schema:        p.Schema,
 		Pretty:        p.Pretty,
 		BeforeRequest: p.BeforeRequest,
 		AfterRequest:  p.AfterRequest,
+
+		Upgrader:              p.Upgrader,
+		KeepAliveInterval:     p.KeepAliveInterval,
+		ConnectionInitTimeout: p.ConnectionInitTimeout,
+		WebSocketAuthFunc:     p.WebSocketAuthFunc,
+
+		GraphiQL:        p.GraphiQL,
+		Playground:      p.Playground,
+		SubscriptionURL: p.SubscriptionURL,
+
+		ComplexityLimit: p.ComplexityLimit,
+		MaxDepth:        p.MaxDepth,
+		APQEnabled:      p.APQEnabled,
+		apqCache:        newAPQCache(p.APQCacheSize),
+
+		BatchingEnabled:  p.BatchingEnabled,
+		MaxBatchSize:     p.MaxBatchSize,
+		BatchWorkerLimit: p.BatchWorkerLimit,
+
+		tracer: chainTracers(p.Tracers...),
+
+		ErrorPresenter: p.ErrorPresenter,
+		RecoverFunc:    p.RecoverFunc,
+		MaxBodyBytes:   p.MaxBodyBytes,
+		Debug:          p.Debug,
 	}
 }
@@ -0,0 +1,72 @@
+package handler
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/bookreport/graphql"
+)
+
+// instrumented tracks which *graphql.FieldDefinition.Resolve funcs have
+// already been wrapped, so sharing one *graphql.Schema across several
+// Handlers (or calling New more than once with the same schema) doesn't
+// wrap a field's resolver more than once.
+var instrumented sync.Map // map[*graphql.FieldDefinition]struct{}
+
+// instrumentSchema wraps every object field's Resolve func in schema so
+// that Tracer.StartField actually fires during execution. Without this,
+// StartField is dead code: graphql.Do has no other hook into per-field
+// resolution.
+func instrumentSchema(schema *graphql.Schema) {
+	if schema == nil {
+		return
+	}
+	for _, t := range schema.TypeMap() {
+		obj, ok := t.(*graphql.Object)
+		if !ok {
+			continue
+		}
+		for _, field := range obj.Fields() {
+			instrumentField(obj.Name(), field)
+		}
+	}
+}
+
+func instrumentField(parentType string, field *graphql.FieldDefinition) {
+	if _, already := instrumented.LoadOrStore(field, struct{}{}); already {
+		return
+	}
+
+	// Most scalar/property-access fields are left with a nil Resolve and
+	// fall back to graphql.DefaultResolveFn at execution time. Wrap that
+	// default explicitly too, rather than skipping the field, so tracing
+	// extensions account for every field the schema resolves, not just
+	// the ones with hand-written resolvers.
+	resolve := field.Resolve
+	if resolve == nil {
+		resolve = graphql.DefaultResolveFn
+	}
+	fieldName := field.Name
+	returnType := typeName(field.Type)
+
+	field.Resolve = func(p graphql.ResolveParams) (interface{}, error) {
+		end := tracerFromContext(p.Context).StartField(p.Context, ResolveInfo{
+			Path:       p.Info.Path,
+			ParentType: parentType,
+			FieldName:  fieldName,
+			ReturnType: returnType,
+		})
+		defer end()
+		return resolve(p)
+	}
+}
+
+// typeName renders t (which may be wrapped in graphql.NonNull/graphql.List)
+// as a human-readable name for tracing, falling back to its String form
+// for named types that don't implement a Name() accessor.
+func typeName(t graphql.Type) string {
+	if named, ok := t.(interface{ Name() string }); ok {
+		return named.Name()
+	}
+	return fmt.Sprintf("%v", t)
+}
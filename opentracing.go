@@ -0,0 +1,90 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/bookreport/graphql"
+	opentracing "github.com/opentracing/opentracing-go"
+	"golang.org/x/net/context"
+)
+
+// extractIncomingSpan pulls an OpenTracing span context propagated via
+// HTTP headers (if any) into ctx so OpenTracingTracer.StartRequest can
+// make it the parent of the request span. It is a no-op when no span was
+// propagated or no OpenTracingTracer is in use.
+func extractIncomingSpan(ctx context.Context, r *http.Request) context.Context {
+	wireCtx, err := opentracing.GlobalTracer().Extract(opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(r.Header))
+	if err != nil {
+		return ctx
+	}
+	return context.WithValue(ctx, openTracingSpanKey{}, wireCtx)
+}
+
+// OpenTracingTracer is a Tracer that emits a parent span per request and
+// child spans for parsing, validation, and field resolution, using
+// whatever opentracing.Tracer is registered globally (which is how both
+// OpenTracing and OpenTelemetry's OpenTracing bridge are wired up).
+type OpenTracingTracer struct {
+	// Tracer is the opentracing.Tracer to use. Defaults to
+	// opentracing.GlobalTracer() when nil.
+	Tracer opentracing.Tracer
+}
+
+// NewOpenTracingTracer constructs an OpenTracingTracer using the given
+// opentracing.Tracer, or the global tracer if nil.
+func NewOpenTracingTracer(tracer opentracing.Tracer) *OpenTracingTracer {
+	return &OpenTracingTracer{Tracer: tracer}
+}
+
+func (t *OpenTracingTracer) tracer() opentracing.Tracer {
+	if t.Tracer != nil {
+		return t.Tracer
+	}
+	return opentracing.GlobalTracer()
+}
+
+type openTracingSpanKey struct{}
+
+func (t *OpenTracingTracer) StartRequest(ctx context.Context, query string) (context.Context, func()) {
+	var opts []opentracing.StartSpanOption
+	if wireCtx, ok := ctx.Value(openTracingSpanKey{}).(opentracing.SpanContext); ok {
+		opts = append(opts, opentracing.ChildOf(wireCtx))
+	}
+
+	span := t.tracer().StartSpan("graphql.request", opts...)
+	span.SetTag("graphql.query", query)
+	ctx = opentracing.ContextWithSpan(ctx, span)
+
+	return ctx, func() { span.Finish() }
+}
+
+func (t *OpenTracingTracer) startChildSpan(ctx context.Context, name string) func() {
+	span, _ := opentracing.StartSpanFromContextWithTracer(ctx, t.tracer(), name)
+	return func() { span.Finish() }
+}
+
+func (t *OpenTracingTracer) StartParse(ctx context.Context) func() {
+	return t.startChildSpan(ctx, "graphql.parse")
+}
+
+func (t *OpenTracingTracer) StartValidate(ctx context.Context) func() {
+	return t.startChildSpan(ctx, "graphql.validate")
+}
+
+func (t *OpenTracingTracer) StartField(ctx context.Context, info ResolveInfo) func() {
+	span, _ := opentracing.StartSpanFromContextWithTracer(ctx, t.tracer(), "graphql.field."+info.FieldName)
+	span.SetTag("graphql.parentType", info.ParentType)
+	span.SetTag("graphql.fieldName", info.FieldName)
+	span.SetTag("graphql.returnType", info.ReturnType)
+	return func() { span.Finish() }
+}
+
+func (t *OpenTracingTracer) EndRequest(ctx context.Context, result *graphql.Result) {
+	span := opentracing.SpanFromContext(ctx)
+	if span == nil {
+		return
+	}
+	if result.HasErrors() {
+		span.SetTag("error", true)
+	}
+}
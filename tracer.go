@@ -0,0 +1,133 @@
+package handler
+
+import (
+	"github.com/bookreport/graphql"
+	"golang.org/x/net/context"
+)
+
+// ResolveInfo describes a single field resolution, passed to
+// Tracer.StartField so implementations can record per-field timing.
+type ResolveInfo struct {
+	Path       []interface{}
+	ParentType string
+	FieldName  string
+	ReturnType string
+}
+
+// Tracer instruments the lifecycle of a single GraphQL request. Config.Tracers
+// holds an ordered chain of Tracers; ContextHandler calls every hook on every
+// tracer in the chain, in order, wrapping graphql.Do.
+type Tracer interface {
+	// StartRequest is called before any work begins and returns a context
+	// (and an end function) to use for the rest of the request.
+	StartRequest(ctx context.Context, query string) (context.Context, func())
+	// StartParse is called immediately before parsing the query. It is not
+	// called when ComplexityLimit or MaxDepth is set: the document parsed
+	// by the complexity check is reused and that parse is not traced.
+	StartParse(ctx context.Context) func()
+	// StartValidate is called immediately before validating the query.
+	StartValidate(ctx context.Context) func()
+	// StartField is called immediately before resolving a single field.
+	StartField(ctx context.Context, info ResolveInfo) func()
+	// EndRequest is called once the response (including any errors) is
+	// known, after graphql.Do returns.
+	EndRequest(ctx context.Context, result *graphql.Result)
+}
+
+// tracerChain composes multiple Tracers into one, invoking each in order
+// and reversing the order for the end-of-scope functions they return.
+type tracerChain struct {
+	tracers []Tracer
+}
+
+// chainTracers combines tracers into a single Tracer so ContextHandler only
+// ever has to drive one. A nil or empty chain does nothing.
+func chainTracers(tracers ...Tracer) Tracer {
+	return &tracerChain{tracers: tracers}
+}
+
+func (c *tracerChain) StartRequest(ctx context.Context, query string) (context.Context, func()) {
+	ends := make([]func(), 0, len(c.tracers))
+	for _, t := range c.tracers {
+		var end func()
+		ctx, end = t.StartRequest(ctx, query)
+		ends = append(ends, end)
+	}
+	return ctx, func() {
+		for i := len(ends) - 1; i >= 0; i-- {
+			ends[i]()
+		}
+	}
+}
+
+func (c *tracerChain) StartParse(ctx context.Context) func() {
+	ends := make([]func(), 0, len(c.tracers))
+	for _, t := range c.tracers {
+		ends = append(ends, t.StartParse(ctx))
+	}
+	return runAll(ends)
+}
+
+func (c *tracerChain) StartValidate(ctx context.Context) func() {
+	ends := make([]func(), 0, len(c.tracers))
+	for _, t := range c.tracers {
+		ends = append(ends, t.StartValidate(ctx))
+	}
+	return runAll(ends)
+}
+
+func (c *tracerChain) StartField(ctx context.Context, info ResolveInfo) func() {
+	ends := make([]func(), 0, len(c.tracers))
+	for _, t := range c.tracers {
+		ends = append(ends, t.StartField(ctx, info))
+	}
+	return runAll(ends)
+}
+
+func (c *tracerChain) EndRequest(ctx context.Context, result *graphql.Result) {
+	for _, t := range c.tracers {
+		t.EndRequest(ctx, result)
+	}
+}
+
+func runAll(ends []func()) func() {
+	return func() {
+		for i := len(ends) - 1; i >= 0; i-- {
+			ends[i]()
+		}
+	}
+}
+
+type tracerContextKey struct{}
+
+// withTracer makes t available to instrumented field resolvers via
+// tracerFromContext, so StartField fires for the tracer chain actually
+// driving the request instead of a fixed tracer captured at schema
+// construction time (the same *graphql.Schema can be served by more than
+// one Handler, each with its own Config.Tracers).
+func withTracer(ctx context.Context, t Tracer) context.Context {
+	return context.WithValue(ctx, tracerContextKey{}, t)
+}
+
+// tracerFromContext returns the Tracer stashed by withTracer, or a no-op
+// Tracer if ctx was never instrumented (e.g. a resolver invoked outside
+// of Handler.execute).
+func tracerFromContext(ctx context.Context) Tracer {
+	if t, ok := ctx.Value(tracerContextKey{}).(Tracer); ok && t != nil {
+		return t
+	}
+	return noopTracer{}
+}
+
+// noopTracer implements Tracer with no-op hooks.
+type noopTracer struct{}
+
+func (noopTracer) StartRequest(ctx context.Context, query string) (context.Context, func()) {
+	return ctx, func() {}
+}
+func (noopTracer) StartParse(ctx context.Context) func()    { return func() {} }
+func (noopTracer) StartValidate(ctx context.Context) func() { return func() {} }
+func (noopTracer) StartField(ctx context.Context, info ResolveInfo) func() {
+	return func() {}
+}
+func (noopTracer) EndRequest(ctx context.Context, result *graphql.Result) {}
@@ -0,0 +1,318 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bookreport/graphql"
+	"github.com/gorilla/websocket"
+	"golang.org/x/net/context"
+)
+
+// The two GraphQL-over-WebSocket sub-protocols this handler understands.
+// "graphql-ws" is the original Apollo protocol, "graphql-transport-ws" is
+// the newer protocol implemented by graphql-ws and most current clients.
+const (
+	subprotocolGraphQLWS          = "graphql-ws"
+	subprotocolGraphQLTransportWS = "graphql-transport-ws"
+)
+
+// Message types of both sub-protocols. Incoming messages are accepted
+// under either name on either wire; outgoing results use msgLegacyData on
+// "graphql-ws" and msgNext on "graphql-transport-ws", and keep-alives use
+// msgConnectionKeepAlive and msgPing respectively.
+const (
+	msgConnectionInit      = "connection_init"
+	msgConnectionAck       = "connection_ack"
+	msgConnectionError     = "connection_error"
+	msgConnectionTerminate = "connection_terminate"
+	msgConnectionKeepAlive = "ka"
+	msgLegacyStart         = "start"
+	msgLegacyStop          = "stop"
+	msgSubscribe           = "subscribe"
+	msgComplete            = "complete"
+	msgNext                = "next"
+	msgLegacyData          = "data"
+	msgError               = "error"
+	msgPing                = "ping"
+	msgPong                = "pong"
+)
+
+// operationMessage is the envelope used by both sub-protocols. Field names
+// line up with the wire format; unused fields are simply left empty.
+type operationMessage struct {
+	ID      string          `json:"id,omitempty"`
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+type startPayload struct {
+	Query         string                 `json:"query"`
+	Variables     map[string]interface{} `json:"variables"`
+	OperationName string                 `json:"operationName"`
+}
+
+// WebSocketAuthFn authenticates (or otherwise augments) a subscription
+// connection from the payload of its connection_init message, returning
+// the context to use for every operation started on that connection.
+type WebSocketAuthFn func(ctx context.Context, initPayload json.RawMessage) (context.Context, error)
+
+func isWebSocketUpgrade(r *http.Request) bool {
+	if !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+		return false
+	}
+	return subProtocol(r) != ""
+}
+
+func subProtocol(r *http.Request) string {
+	for _, p := range websocket.Subprotocols(r) {
+		if p == subprotocolGraphQLWS || p == subprotocolGraphQLTransportWS {
+			return p
+		}
+	}
+	return ""
+}
+
+// wsConnection multiplexes many subscription IDs over a single socket.
+type wsConnection struct {
+	handler  *Handler
+	conn     *websocket.Conn
+	protocol string
+
+	writeMu sync.Mutex
+
+	mu     sync.Mutex
+	ctx    context.Context
+	cancel map[string]context.CancelFunc
+}
+
+// ServeWebSocket upgrades r and runs the subscription protocol negotiated
+// in Sec-WebSocket-Protocol until the client disconnects.
+func (h *Handler) ServeWebSocket(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	protocol := subProtocol(r)
+
+	// Copy the shared upgrader rather than mutating it in place: Subprotocols
+	// is request-specific (it depends on which sub-protocol this particular
+	// client negotiated) but h.Upgrader is one instance shared by every
+	// concurrent connection.
+	var upgrader websocket.Upgrader
+	if h.Upgrader != nil {
+		upgrader = *h.Upgrader
+	}
+	upgrader.Subprotocols = []string{protocol}
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+
+	c := &wsConnection{
+		handler:  h,
+		conn:     conn,
+		protocol: protocol,
+		ctx:      ctx,
+		cancel:   make(map[string]context.CancelFunc),
+	}
+	c.run()
+}
+
+func (c *wsConnection) run() {
+	defer c.conn.Close()
+
+	initTimeout := c.handler.ConnectionInitTimeout
+	if initTimeout <= 0 {
+		initTimeout = 10 * time.Second
+	}
+	c.conn.SetReadDeadline(time.Now().Add(initTimeout))
+
+	var initialized bool
+	var stop chan struct{}
+
+readLoop:
+	for {
+		var msg operationMessage
+		if err := c.conn.ReadJSON(&msg); err != nil {
+			break
+		}
+
+		switch msg.Type {
+		case msgConnectionInit:
+			ctx := c.ctx
+			if c.handler.WebSocketAuthFunc != nil {
+				var authErr error
+				ctx, authErr = c.handler.WebSocketAuthFunc(ctx, msg.Payload)
+				if authErr != nil {
+					c.writeMessage(operationMessage{Type: msgConnectionError, Payload: rawString(authErr.Error())})
+					return
+				}
+			}
+			c.mu.Lock()
+			c.ctx = ctx
+			c.mu.Unlock()
+
+			c.conn.SetReadDeadline(time.Time{})
+			initialized = true
+			c.writeMessage(operationMessage{Type: msgConnectionAck})
+
+			if iv := c.handler.KeepAliveInterval; iv > 0 {
+				stop = make(chan struct{})
+				go c.keepAlive(iv, stop)
+			}
+
+		case msgLegacyStart, msgSubscribe:
+			if !initialized {
+				return
+			}
+			c.startOperation(msg)
+
+		case msgLegacyStop, msgComplete:
+			c.stopOperation(msg.ID)
+
+		case msgPing:
+			c.writeMessage(operationMessage{Type: msgPong})
+
+		case msgConnectionTerminate:
+			// break the loop (not return) so the cleanup below still
+			// runs and tears down every active subscription, exactly
+			// like the read-error disconnect path does.
+			break readLoop
+		}
+	}
+
+	if stop != nil {
+		close(stop)
+	}
+	c.stopAll()
+}
+
+func (c *wsConnection) keepAlive(interval time.Duration, stop chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if c.protocol == subprotocolGraphQLWS {
+				c.writeMessage(operationMessage{Type: msgConnectionKeepAlive})
+			} else {
+				c.writeMessage(operationMessage{Type: msgPing})
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+func (c *wsConnection) startOperation(msg operationMessage) {
+	var payload startPayload
+	if err := json.Unmarshal(msg.Payload, &payload); err != nil {
+		c.writeMessage(operationMessage{ID: msg.ID, Type: msgError, Payload: rawString(err.Error())})
+		return
+	}
+
+	c.mu.Lock()
+	parentCtx := c.ctx
+	if _, exists := c.cancel[msg.ID]; exists {
+		c.mu.Unlock()
+		return
+	}
+	opCtx, cancel := context.WithCancel(parentCtx)
+	c.cancel[msg.ID] = cancel
+	c.mu.Unlock()
+
+	go c.runOperation(opCtx, msg.ID, payload)
+}
+
+func (c *wsConnection) runOperation(ctx context.Context, id string, payload startPayload) {
+	defer c.stopOperation(id)
+
+	appLocation := ""
+	authToken := ""
+	requestID := time.Now().UnixNano()
+	c.handler.BeforeRequest(0, appLocation, payload.Query, authToken, requestID)
+	start := time.Now()
+
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			result := c.handler.recoverAsResult(ctx, recovered)
+			buff, _ := json.Marshal(result)
+			dataType := msgNext
+			if c.protocol == subprotocolGraphQLWS {
+				dataType = msgLegacyData
+			}
+			c.writeMessage(operationMessage{ID: id, Type: dataType, Payload: buff})
+			c.writeMessage(operationMessage{ID: id, Type: msgComplete})
+			c.handler.AfterRequest(time.Since(start), appLocation, payload.Query, authToken, requestID)
+		}
+	}()
+
+	root := make(map[string]interface{})
+	results := graphql.Subscribe(graphql.Params{
+		Schema:         *c.handler.Schema,
+		RequestString:  payload.Query,
+		VariableValues: payload.Variables,
+		OperationName:  payload.OperationName,
+		Context:        ctx,
+		RootObject:     root,
+	})
+
+	dataType := msgNext
+	if c.protocol == subprotocolGraphQLWS {
+		dataType = msgLegacyData
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case result, ok := <-results:
+			if !ok {
+				c.writeMessage(operationMessage{ID: id, Type: msgComplete})
+				c.handler.AfterRequest(time.Since(start), appLocation, payload.Query, authToken, requestID)
+				return
+			}
+			buff, err := json.Marshal(result)
+			if err != nil {
+				continue
+			}
+			c.writeMessage(operationMessage{ID: id, Type: dataType, Payload: buff})
+		}
+	}
+}
+
+func (c *wsConnection) stopOperation(id string) {
+	c.mu.Lock()
+	cancel, ok := c.cancel[id]
+	if ok {
+		delete(c.cancel, id)
+	}
+	c.mu.Unlock()
+
+	if ok {
+		cancel()
+	}
+}
+
+func (c *wsConnection) stopAll() {
+	c.mu.Lock()
+	cancels := c.cancel
+	c.cancel = make(map[string]context.CancelFunc)
+	c.mu.Unlock()
+
+	for _, cancel := range cancels {
+		cancel()
+	}
+}
+
+func (c *wsConnection) writeMessage(msg operationMessage) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	c.conn.WriteJSON(msg)
+}
+
+func rawString(s string) json.RawMessage {
+	buff, _ := json.Marshal(s)
+	return buff
+}